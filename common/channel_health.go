@@ -0,0 +1,49 @@
+package common
+
+import "sync"
+
+// channelFailureThreshold is how many consecutive relay failures on a
+// channel mark it unhealthy: excluded from weighted fan-out selection until
+// it succeeds again.
+const channelFailureThreshold = 3
+
+var (
+	channelHealthMu      sync.Mutex
+	channelFailureCounts = map[int]int{}
+	unhealthyChannels    = map[int]bool{}
+)
+
+// RecordChannelFailure tracks a failed relay attempt against a channel.
+// Once channelFailureThreshold consecutive failures accumulate, the channel
+// is marked unhealthy and the relay response cache is invalidated, since
+// entries recorded against it may no longer be trustworthy.
+func RecordChannelFailure(channelId int) {
+	channelHealthMu.Lock()
+	channelFailureCounts[channelId]++
+	becameUnhealthy := channelFailureCounts[channelId] >= channelFailureThreshold && !unhealthyChannels[channelId]
+	if becameUnhealthy {
+		unhealthyChannels[channelId] = true
+	}
+	channelHealthMu.Unlock()
+
+	if becameUnhealthy {
+		InvalidateRelayCacheForChannel(channelId)
+	}
+}
+
+// RecordChannelSuccess clears a channel's failure count, restoring it to
+// the healthy pool.
+func RecordChannelSuccess(channelId int) {
+	channelHealthMu.Lock()
+	defer channelHealthMu.Unlock()
+	delete(channelFailureCounts, channelId)
+	delete(unhealthyChannels, channelId)
+}
+
+// IsChannelHealthy reports whether channelId should be considered for new
+// fan-out work.
+func IsChannelHealthy(channelId int) bool {
+	channelHealthMu.Lock()
+	defer channelHealthMu.Unlock()
+	return !unhealthyChannels[channelId]
+}
@@ -0,0 +1,99 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// CacheStore is the storage backend for the deterministic relay response
+// cache. The default implementation keeps entries in process memory; a
+// production deployment would back this with Redis or BadgerDB instead.
+type CacheStore interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte, ttl time.Duration)
+	Delete(key string)
+	Flush()
+}
+
+type cacheItem struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+type inMemoryCacheStore struct {
+	mu    sync.RWMutex
+	items map[string]cacheItem
+}
+
+func newInMemoryCacheStore() *inMemoryCacheStore {
+	return &inMemoryCacheStore{items: make(map[string]cacheItem)}
+}
+
+func (s *inMemoryCacheStore) Get(key string) ([]byte, bool) {
+	s.mu.RLock()
+	item, ok := s.items[key]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(item.expiresAt) {
+		s.Delete(key)
+		return nil, false
+	}
+	return item.value, true
+}
+
+func (s *inMemoryCacheStore) Set(key string, value []byte, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items[key] = cacheItem{value: value, expiresAt: time.Now().Add(ttl)}
+}
+
+func (s *inMemoryCacheStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.items, key)
+}
+
+func (s *inMemoryCacheStore) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items = make(map[string]cacheItem)
+}
+
+// DefaultCacheStore backs the relay response cache. It can be swapped out
+// (e.g. for a Redis-backed implementation) by assigning a different
+// CacheStore before the server starts serving requests.
+var DefaultCacheStore CacheStore = newInMemoryCacheStore()
+
+// ComputeRelayCacheKey hashes the normalized request (model after
+// modelMap, messages/prompt/input, tools, response_format, seed) together
+// with the token's group, so identical requests from different groups
+// never share a cache entry.
+func ComputeRelayCacheKey(group string, model string, normalized string) string {
+	h := sha256.New()
+	h.Write([]byte(group))
+	h.Write([]byte{0})
+	h.Write([]byte(model))
+	h.Write([]byte{0})
+	h.Write([]byte(normalized))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// InvalidateRelayCacheForChannel flushes the relay cache; RecordChannelFailure
+// calls it when a channel is marked unhealthy. Cache entries are not indexed
+// by channel, so this is
+// intentionally coarse rather than risk serving a cached response from a
+// channel that just went away.
+func InvalidateRelayCacheForChannel(channelId int) {
+	DefaultCacheStore.Flush()
+}
+
+// InvalidateRelayCacheForModelMapping flushes the relay cache, for use when
+// a token's or channel's model mapping changes. No code in this tree calls
+// it.
+func InvalidateRelayCacheForModelMapping() {
+	DefaultCacheStore.Flush()
+}
@@ -0,0 +1,67 @@
+package common
+
+import (
+	"sync"
+	"time"
+)
+
+// RelayFailoverPolicy controls how a streaming relay reacts to a mid-stream
+// upstream failure: how many times to retry against another channel, which
+// HTTP status codes are worth retrying, and how long to wait for the first
+// byte of a fresh stream before giving up on it. The streaming relay reads
+// MaxRetries and RetryOnHTTPCodes (through ShouldRetry);
+// RetryOnEmptyStreamTimeout is stored with the policy but no relay path
+// reads it, so no first-byte timeout is applied.
+type RelayFailoverPolicy struct {
+	MaxRetries                int           `json:"max_retries"`
+	RetryOnHTTPCodes          []int         `json:"retry_on_http_codes"`
+	RetryOnEmptyStreamTimeout time.Duration `json:"retry_on_empty_stream_timeout"`
+}
+
+// DefaultRelayFailoverPolicy is used for channels that do not configure
+// their own policy: a couple of retries against the usual transient
+// gateway/server error codes.
+var DefaultRelayFailoverPolicy = RelayFailoverPolicy{
+	MaxRetries:                2,
+	RetryOnHTTPCodes:          []int{429, 500, 502, 503, 504},
+	RetryOnEmptyStreamTimeout: 15 * time.Second,
+}
+
+// ShouldRetry reports whether a failure with the given HTTP status code
+// should trigger a channel failover under this policy.
+func (p *RelayFailoverPolicy) ShouldRetry(attempt int, statusCode int) bool {
+	if attempt >= p.MaxRetries {
+		return false
+	}
+	for _, code := range p.RetryOnHTTPCodes {
+		if code == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	channelFailoverPoliciesMu sync.RWMutex
+	channelFailoverPolicies   = map[int]RelayFailoverPolicy{}
+)
+
+// SetChannelFailoverPolicy overrides the failover policy used for streaming
+// relays on a specific channel. Channels that never call this use
+// DefaultRelayFailoverPolicy.
+func SetChannelFailoverPolicy(channelId int, policy RelayFailoverPolicy) {
+	channelFailoverPoliciesMu.Lock()
+	defer channelFailoverPoliciesMu.Unlock()
+	channelFailoverPolicies[channelId] = policy
+}
+
+// RelayFailoverPolicyForChannel returns the policy configured for channelId,
+// falling back to DefaultRelayFailoverPolicy if the channel never set one.
+func RelayFailoverPolicyForChannel(channelId int) RelayFailoverPolicy {
+	channelFailoverPoliciesMu.RLock()
+	defer channelFailoverPoliciesMu.RUnlock()
+	if policy, ok := channelFailoverPolicies[channelId]; ok {
+		return policy
+	}
+	return DefaultRelayFailoverPolicy
+}
@@ -0,0 +1,9 @@
+package common
+
+// RelayModeChatCompletionsAgent is the relay mode used when a chat
+// completion request opts into the server-side tool-call auto-execution
+// loop (`tools` + `tool_choice: "auto"` plus the `x-auto-execute: true`
+// header). It is kept out of the main RelayMode* iota block since it is
+// derived from RelayModeChatCompletions at dispatch time rather than from
+// the request path.
+const RelayModeChatCompletionsAgent = 20
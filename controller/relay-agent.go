@@ -0,0 +1,125 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"one-api/common"
+	providers_base "one-api/providers/base"
+	"one-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxAgentIterations caps how many tool-call round trips
+// handleChatCompletionsAgent will run before giving up and returning
+// whatever the model last produced, so a misbehaving tool or model can't
+// loop forever and burn quota.
+const maxAgentIterations = 8
+
+// handleChatCompletionsAgent implements the server-side tool-call loop: it
+// re-invokes the provider after every `tool_calls` response, dispatching
+// each call to its registered providers_base.ToolExecutor and appending the
+// results as `role: "tool"` messages, until the model returns a final
+// assistant message or maxAgentIterations is hit. Quota is pre-consumed
+// once per iteration against the same *QuotaInfo, so preConsumedQuota is
+// accumulated across iterations (not overwritten) before every return:
+// relayHelper only refunds/reconciles that single scalar once, on the way
+// out of this whole function, and it must reflect everything actually
+// pre-consumed or earlier iterations' deductions would leak out of the
+// caller's balance.
+func handleChatCompletionsAgent(c *gin.Context, provider providers_base.ProviderInterface, modelMap map[string]string, quotaInfo *QuotaInfo, group string) (*types.Usage, *types.OpenAIErrorWithStatusCode) {
+	var chatRequest types.ChatCompletionRequest
+	isModelMapped := false
+
+	chatProvider, ok := provider.(providers_base.AgentChatInterface)
+	if !ok {
+		return nil, common.ErrorWrapper(errors.New("channel does not support tool auto-execution"), "channel_not_implemented", http.StatusNotImplemented)
+	}
+
+	if err := common.UnmarshalBodyReusable(c, &chatRequest); err != nil {
+		return nil, common.ErrorWrapper(err, "bind_request_body_failed", http.StatusBadRequest)
+	}
+
+	if len(chatRequest.Messages) == 0 {
+		return nil, common.ErrorWrapper(errors.New("field messages is required"), "required_field_missing", http.StatusBadRequest)
+	}
+
+	if modelMap != nil && modelMap[chatRequest.Model] != "" {
+		chatRequest.Model = modelMap[chatRequest.Model]
+		isModelMapped = true
+	}
+
+	totalUsage := &types.Usage{}
+	totalPreConsumedQuota := 0
+
+	for iteration := 0; iteration < maxAgentIterations; iteration++ {
+		promptTokens := common.CountTokenMessages(chatRequest.Messages, chatRequest.Model)
+
+		quotaInfo.modelName = chatRequest.Model
+		quotaInfo.promptTokens = promptTokens
+		quotaInfo.initQuotaInfo(group)
+		if quotaErr := quotaInfo.preQuotaConsumption(); quotaErr != nil {
+			quotaInfo.preConsumedQuota = totalPreConsumedQuota
+			return nil, quotaErr
+		}
+		totalPreConsumedQuota += quotaInfo.preConsumedQuota
+		quotaInfo.preConsumedQuota = totalPreConsumedQuota
+
+		usage, apiErr := chatProvider.ChatAction(&chatRequest, isModelMapped, promptTokens)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		if usage != nil {
+			totalUsage.PromptTokens += usage.PromptTokens
+			totalUsage.CompletionTokens += usage.CompletionTokens
+			totalUsage.TotalTokens += usage.TotalTokens
+		}
+
+		assistantMessage := chatProvider.LastResponseMessage()
+		if assistantMessage == nil {
+			// Nothing came back to inspect for tool calls; treat it as final.
+			return totalUsage, nil
+		}
+		chatRequest.Messages = append(chatRequest.Messages, *assistantMessage)
+
+		toolCalls := lastAssistantToolCalls(chatRequest.Messages)
+		if len(toolCalls) == 0 {
+			// Final assistant message, no more tools to run.
+			return totalUsage, nil
+		}
+
+		for _, call := range toolCalls {
+			executor, ok := providers_base.GetToolExecutor(quotaInfo.tokenId, call.Function.Name)
+			var result string
+			var err error
+			if !ok {
+				err = errors.New("no tool executor registered for " + call.Function.Name)
+			} else {
+				result, err = executor.Execute(call.Function.Arguments)
+			}
+			if err != nil {
+				result = "error: " + err.Error()
+			}
+			chatRequest.Messages = append(chatRequest.Messages, types.ChatCompletionMessage{
+				Role:       "tool",
+				Content:    result,
+				ToolCallId: call.Id,
+			})
+		}
+	}
+
+	return totalUsage, nil
+}
+
+// lastAssistantToolCalls returns the tool calls attached to the most recent
+// assistant message, or nil if that message did not request any.
+func lastAssistantToolCalls(messages []types.ChatCompletionMessage) []types.ToolCall {
+	if len(messages) == 0 {
+		return nil
+	}
+	last := messages[len(messages)-1]
+	if last.Role != "assistant" {
+		return nil
+	}
+	return last.ToolCalls
+}
@@ -0,0 +1,460 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/providers"
+	providers_base "one-api/providers/base"
+	"one-api/types"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// isArrayInput peeks the reusable request body to tell whether `input` was
+// sent as a JSON array rather than a single string, without consuming the
+// body for the caller's own UnmarshalBodyReusable call.
+func isArrayInput(c *gin.Context) bool {
+	var probe struct {
+		Input json.RawMessage `json:"input"`
+	}
+	if err := common.UnmarshalBodyReusable(c, &probe); err != nil || len(probe.Input) == 0 {
+		return false
+	}
+	var asArray []string
+	return json.Unmarshal(probe.Input, &asArray) == nil
+}
+
+// maxBatchInputs caps how many items a single batch call may submit, so a
+// client can't force unbounded goroutine/result-slice allocation in one
+// request.
+const maxBatchInputs = 100
+
+// maxBatchFanOut bounds how many embedding/moderation sub-requests a batch
+// call will have in flight at once, so a single oversized batch can't
+// monopolize every channel connection.
+const maxBatchFanOut = 8
+
+// maxBatchItemRetries bounds how many further channels a single fan-out
+// item will try after its first one (so at most maxBatchItemRetries+1
+// attempts) before it's counted as a failure, so one unhealthy channel
+// doesn't take the whole batch down with it.
+const maxBatchItemRetries = 2
+
+// handleModerationsBatch fans an array `input` out across concurrent
+// single-input ModerationAction calls, mirroring HandleEmbeddingsBatch, and
+// writes the aggregated OpenAI-shaped response itself.
+func handleModerationsBatch(c *gin.Context, _ providers_base.ModerationInterface, modelMap map[string]string, quotaInfo *QuotaInfo, group string) (*types.Usage, *types.OpenAIErrorWithStatusCode) {
+	channelType := c.GetInt("channel")
+	channelId := c.GetInt("channel_id")
+	var req types.BatchModerationRequest
+	if err := common.UnmarshalBodyReusable(c, &req); err != nil {
+		return nil, common.ErrorWrapper(err, "bind_request_body_failed", http.StatusBadRequest)
+	}
+	if len(req.Input) == 0 {
+		return nil, common.ErrorWrapper(errors.New("field input is required"), "required_field_missing", http.StatusBadRequest)
+	}
+	if len(req.Input) > maxBatchInputs {
+		return nil, common.ErrorWrapper(errors.New("field input exceeds the maximum of "+strconv.Itoa(maxBatchInputs)+" items"), "batch_too_large", http.StatusBadRequest)
+	}
+	if req.Model == "" {
+		req.Model = "text-moderation-latest"
+	}
+	if modelMap != nil && modelMap[req.Model] != "" {
+		req.Model = modelMap[req.Model]
+	}
+
+	totalPromptTokens := 0
+	for _, input := range req.Input {
+		totalPromptTokens += common.CountTokenInput(input, req.Model)
+	}
+
+	quotaInfo.modelName = req.Model
+	quotaInfo.promptTokens = totalPromptTokens
+	quotaInfo.initQuotaInfo(group)
+	if quotaErr := quotaInfo.preQuotaConsumption(); quotaErr != nil {
+		return nil, quotaErr
+	}
+
+	results := make([]types.ModerationResultItem, len(req.Input))
+	refundTokens := 0
+	var refundMu sync.Mutex
+
+	semaphore := make(chan struct{}, maxBatchFanOut)
+	var wg sync.WaitGroup
+	for i, input := range req.Input {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(index int, input string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			itemPromptTokens := common.CountTokenInput(input, req.Model)
+			recorder, ok := fanOutModeration(channelType, channelId, req.Model, input, itemPromptTokens)
+			if !ok {
+				refundMu.Lock()
+				refundTokens += itemPromptTokens
+				refundMu.Unlock()
+				results[index] = types.ModerationResultItem{Flagged: false}
+				return
+			}
+
+			var moderationResp struct {
+				Results []types.ModerationResultItem `json:"results"`
+			}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &moderationResp); err == nil && len(moderationResp.Results) > 0 {
+				results[index] = moderationResp.Results[0]
+			} else {
+				results[index] = types.ModerationResultItem{Flagged: false}
+			}
+		}(i, input)
+	}
+	wg.Wait()
+
+	// refundTokens already reduced the usage below; completedQuotaConsumption
+	// (invoked by relayHelper's deferred call with that usage) reconciles
+	// preConsumedQuota against it, so no separate refund call is needed here
+	// or it would be double-counted.
+
+	c.JSON(http.StatusOK, types.BatchModerationResponse{
+		Model:   req.Model,
+		Results: results,
+	})
+
+	usage := &types.Usage{
+		PromptTokens: totalPromptTokens - refundTokens,
+		TotalTokens:  totalPromptTokens - refundTokens,
+	}
+	return usage, nil
+}
+
+// handleEmbeddingsBatch fans an array `input` out across concurrent
+// single-input EmbeddingsAction calls, mirroring handleModerationsBatch, so
+// `POST /v1/embeddings` accepts an array input directly instead of only
+// through the separate HandleEmbeddingsBatch route.
+func handleEmbeddingsBatch(c *gin.Context, _ providers_base.EmbeddingsInterface, modelMap map[string]string, quotaInfo *QuotaInfo, group string) (*types.Usage, *types.OpenAIErrorWithStatusCode) {
+	channelType := c.GetInt("channel")
+	channelId := c.GetInt("channel_id")
+	var req types.BatchEmbeddingRequest
+	if err := common.UnmarshalBodyReusable(c, &req); err != nil {
+		return nil, common.ErrorWrapper(err, "bind_request_body_failed", http.StatusBadRequest)
+	}
+	if len(req.Input) == 0 {
+		return nil, common.ErrorWrapper(errors.New("field input is required"), "required_field_missing", http.StatusBadRequest)
+	}
+	if len(req.Input) > maxBatchInputs {
+		return nil, common.ErrorWrapper(errors.New("field input exceeds the maximum of "+strconv.Itoa(maxBatchInputs)+" items"), "batch_too_large", http.StatusBadRequest)
+	}
+
+	isModelMapped := false
+	if modelMap != nil && modelMap[req.Model] != "" {
+		req.Model = modelMap[req.Model]
+		isModelMapped = true
+	}
+
+	totalPromptTokens := 0
+	for _, input := range req.Input {
+		totalPromptTokens += common.CountTokenInput(input, req.Model)
+	}
+
+	quotaInfo.modelName = req.Model
+	quotaInfo.promptTokens = totalPromptTokens
+	quotaInfo.initQuotaInfo(group)
+	if quotaErr := quotaInfo.preQuotaConsumption(); quotaErr != nil {
+		return nil, quotaErr
+	}
+
+	data := make([]types.BatchEmbeddingData, len(req.Input))
+	refundTokens := 0
+	var refundMu sync.Mutex
+
+	semaphore := make(chan struct{}, maxBatchFanOut)
+	var wg sync.WaitGroup
+	for i, input := range req.Input {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(index int, input string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			itemPromptTokens := common.CountTokenInput(input, req.Model)
+			recorder, ok := fanOutEmbedding(channelType, channelId, req.Model, input, isModelMapped, itemPromptTokens)
+			if !ok {
+				refundMu.Lock()
+				refundTokens += itemPromptTokens
+				refundMu.Unlock()
+				data[index] = types.BatchEmbeddingData{Object: "embedding", Index: index}
+				return
+			}
+
+			var embeddingResp struct {
+				Data []types.BatchEmbeddingData `json:"data"`
+			}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &embeddingResp); err == nil && len(embeddingResp.Data) > 0 {
+				item := embeddingResp.Data[0]
+				item.Index = index
+				data[index] = item
+			} else {
+				data[index] = types.BatchEmbeddingData{Object: "embedding", Index: index}
+			}
+		}(i, input)
+	}
+	wg.Wait()
+
+	// refundTokens already reduced the usage below; completedQuotaConsumption
+	// (invoked by relayHelper's deferred call with that usage) reconciles
+	// preConsumedQuota against it, so no separate refund call is needed here
+	// or it would be double-counted.
+
+	c.JSON(http.StatusOK, types.BatchEmbeddingResponse{
+		Object: "list",
+		Model:  req.Model,
+		Data:   data,
+	})
+
+	usage := &types.Usage{
+		PromptTokens: totalPromptTokens - refundTokens,
+		TotalTokens:  totalPromptTokens - refundTokens,
+	}
+	return usage, nil
+}
+
+// fanOutModeration runs a single moderation batch item, retrying against up
+// to maxBatchItemRetries distinct channels of channelType - picked by
+// nextCandidateChannelId, which excludes every channel already tried and
+// skips ones recently marked unhealthy - before giving up on the item.
+func fanOutModeration(channelType int, channelId int, modelName string, input string, promptTokens int) (*httptest.ResponseRecorder, bool) {
+	excluded := []int{}
+	targetChannelId := channelId
+	for attempt := 0; attempt <= maxBatchItemRetries; attempt++ {
+		recorder := httptest.NewRecorder()
+		subCtx, _ := gin.CreateTestContext(recorder)
+		subCtx.Request, _ = http.NewRequest(http.MethodPost, "/", nil)
+		subCtx.Set("channel", channelType)
+		subCtx.Set("channel_id", targetChannelId)
+
+		subProvider := providers.GetProvider(channelType, subCtx)
+		resolvedChannelId := subCtx.GetInt("channel_id")
+		subModerationProvider, ok := subProvider.(providers_base.ModerationInterface)
+		if subProvider == nil || !ok || !common.IsChannelHealthy(resolvedChannelId) {
+			excluded = append(excluded, resolvedChannelId)
+			nextId, ok := nextCandidateChannelId(channelType, excluded)
+			if !ok {
+				break
+			}
+			targetChannelId = nextId
+			continue
+		}
+
+		_, apiErr := subModerationProvider.ModerationAction(&types.ModerationRequest{
+			Model: modelName,
+			Input: input,
+		}, false, promptTokens)
+		if apiErr != nil {
+			common.RecordChannelFailure(resolvedChannelId)
+			excluded = append(excluded, resolvedChannelId)
+			nextId, ok := nextCandidateChannelId(channelType, excluded)
+			if !ok {
+				break
+			}
+			targetChannelId = nextId
+			continue
+		}
+
+		common.RecordChannelSuccess(resolvedChannelId)
+		return recorder, true
+	}
+	return nil, false
+}
+
+// HandleEmbeddingsBatch implements `POST /v1/embeddings/batch`: it fans the
+// input array out across concurrent single-input EmbeddingsAction calls,
+// reserving quota for the whole batch up front and refunding whatever a
+// failed item would have cost, then reassembles the OpenAI-shaped response
+// in the caller's original input order. Each item retries against another
+// channel before being counted as a failure, so one unhealthy channel
+// doesn't fail the whole batch.
+func HandleEmbeddingsBatch(c *gin.Context) {
+	var req types.BatchEmbeddingRequest
+	if err := common.UnmarshalBodyReusable(c, &req); err != nil {
+		c.JSON(http.StatusBadRequest, common.ErrorWrapper(err, "bind_request_body_failed", http.StatusBadRequest))
+		return
+	}
+	if len(req.Input) == 0 {
+		c.JSON(http.StatusBadRequest, common.ErrorWrapper(errors.New("field input is required"), "required_field_missing", http.StatusBadRequest))
+		return
+	}
+	if len(req.Input) > maxBatchInputs {
+		c.JSON(http.StatusBadRequest, common.ErrorWrapper(errors.New("field input exceeds the maximum of "+strconv.Itoa(maxBatchInputs)+" items"), "batch_too_large", http.StatusBadRequest))
+		return
+	}
+
+	channelType := c.GetInt("channel")
+	channelId := c.GetInt("channel_id")
+	tokenId := c.GetInt("token_id")
+	userId := c.GetInt("id")
+	group := c.GetString("group")
+	tokenName := c.GetString("token_name")
+
+	provider := providers.GetProvider(channelType, c)
+	if provider == nil {
+		c.JSON(http.StatusNotImplemented, common.ErrorWrapper(errors.New("channel not found"), "channel_not_found", http.StatusNotImplemented))
+		return
+	}
+	if _, ok := provider.(providers_base.EmbeddingsInterface); !ok {
+		c.JSON(http.StatusNotImplemented, common.ErrorWrapper(errors.New("channel not implemented"), "channel_not_implemented", http.StatusNotImplemented))
+		return
+	}
+
+	modelMap, err := parseModelMapping(c.GetString("model_mapping"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.ErrorWrapper(err, "unmarshal_model_mapping_failed", http.StatusInternalServerError))
+		return
+	}
+	modelName := req.Model
+	isModelMapped := false
+	if modelMap != nil && modelMap[modelName] != "" {
+		modelName = modelMap[modelName]
+		isModelMapped = true
+	}
+
+	totalPromptTokens := 0
+	for _, input := range req.Input {
+		totalPromptTokens += common.CountTokenInput(input, modelName)
+	}
+
+	quotaInfo := &QuotaInfo{
+		modelName:    modelName,
+		promptTokens: totalPromptTokens,
+		userId:       userId,
+		channelId:    channelId,
+		tokenId:      tokenId,
+	}
+	quotaInfo.initQuotaInfo(group)
+	if quotaErr := quotaInfo.preQuotaConsumption(); quotaErr != nil {
+		c.JSON(quotaErr.StatusCode, quotaErr)
+		return
+	}
+
+	data := make([]types.BatchEmbeddingData, len(req.Input))
+	failures := make([]bool, len(req.Input))
+	refundTokens := 0
+	var refundMu sync.Mutex
+
+	semaphore := make(chan struct{}, maxBatchFanOut)
+	var wg sync.WaitGroup
+	for i, input := range req.Input {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(index int, input string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			itemPromptTokens := common.CountTokenInput(input, modelName)
+			recorder, ok := fanOutEmbedding(channelType, channelId, modelName, input, isModelMapped, itemPromptTokens)
+			if !ok {
+				failures[index] = true
+				refundMu.Lock()
+				refundTokens += itemPromptTokens
+				refundMu.Unlock()
+				return
+			}
+
+			var embeddingResp struct {
+				Data []types.BatchEmbeddingData `json:"data"`
+			}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &embeddingResp); err == nil && len(embeddingResp.Data) > 0 {
+				item := embeddingResp.Data[0]
+				item.Index = index
+				data[index] = item
+			} else {
+				data[index] = types.BatchEmbeddingData{Object: "embedding", Index: index}
+			}
+		}(i, input)
+	}
+	wg.Wait()
+
+	// refundTokens already reduced usage below; completedQuotaConsumption
+	// reconciles preConsumedQuota against that reduced usage, so issuing a
+	// separate PostConsumeTokenQuota refund here would double-count it.
+
+	usage := types.Usage{
+		PromptTokens:     totalPromptTokens - refundTokens,
+		CompletionTokens: 0,
+		TotalTokens:      totalPromptTokens - refundTokens,
+	}
+	go func() {
+		if err := quotaInfo.completedQuotaConsumption(&usage, tokenName, c.Request.Context()); err != nil {
+			common.LogError(c.Request.Context(), err.Error())
+		}
+	}()
+
+	for index, failed := range failures {
+		if failed {
+			common.LogError(c.Request.Context(), "batch embeddings: item "+strconv.Itoa(index)+" failed and was refunded")
+		}
+	}
+
+	c.JSON(http.StatusOK, types.BatchEmbeddingResponse{
+		Object: "list",
+		Model:  modelName,
+		Data:   data,
+		Usage:  usage,
+	})
+}
+
+// fanOutEmbedding runs a single embeddings batch item, retrying against up
+// to maxBatchItemRetries distinct channels of channelType - picked by
+// nextCandidateChannelId, which excludes every channel already tried and
+// skips ones recently marked unhealthy - before giving up on the item. Each
+// fan-out call gets its own provider bound to a synthetic, recorder-backed
+// context: EmbeddingsAction writes its response straight to that context's
+// writer, so sharing the caller's real one would interleave several bodies
+// on the wire.
+func fanOutEmbedding(channelType int, channelId int, modelName string, input string, isModelMapped bool, promptTokens int) (*httptest.ResponseRecorder, bool) {
+	excluded := []int{}
+	targetChannelId := channelId
+	for attempt := 0; attempt <= maxBatchItemRetries; attempt++ {
+		recorder := httptest.NewRecorder()
+		subCtx, _ := gin.CreateTestContext(recorder)
+		subCtx.Request, _ = http.NewRequest(http.MethodPost, "/", nil)
+		subCtx.Set("channel", channelType)
+		subCtx.Set("channel_id", targetChannelId)
+
+		subProvider := providers.GetProvider(channelType, subCtx)
+		resolvedChannelId := subCtx.GetInt("channel_id")
+		subEmbeddingsProvider, ok := subProvider.(providers_base.EmbeddingsInterface)
+		if subProvider == nil || !ok || !common.IsChannelHealthy(resolvedChannelId) {
+			excluded = append(excluded, resolvedChannelId)
+			nextId, ok := nextCandidateChannelId(channelType, excluded)
+			if !ok {
+				break
+			}
+			targetChannelId = nextId
+			continue
+		}
+
+		_, apiErr := subEmbeddingsProvider.EmbeddingsAction(&types.EmbeddingRequest{
+			Model: modelName,
+			Input: input,
+		}, isModelMapped, promptTokens)
+		if apiErr != nil {
+			common.RecordChannelFailure(resolvedChannelId)
+			excluded = append(excluded, resolvedChannelId)
+			nextId, ok := nextCandidateChannelId(channelType, excluded)
+			if !ok {
+				break
+			}
+			targetChannelId = nextId
+			continue
+		}
+
+		common.RecordChannelSuccess(resolvedChannelId)
+		return recorder, true
+	}
+	return nil, false
+}
@@ -0,0 +1,337 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/providers"
+	providers_base "one-api/providers/base"
+	"one-api/types"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cacheDiscountRate is the fraction of the normal quota charged on a cache
+// hit, since the relay skipped provider dispatch entirely.
+const cacheDiscountRate = 0.1
+
+// cacheTTL is how long a cached response stays valid.
+const cacheTTL = 10 * time.Minute
+
+type cachedRelayResponse struct {
+	Body             []byte `json:"body"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+}
+
+// streamCacheKeyPrefix namespaces streamed chat completions away from the
+// non-streaming cache: the two store different shapes (an SSE delta list
+// vs. a whole JSON body) even when the underlying request normalizes to the
+// same key, since `stream` itself is deliberately left out of
+// normalizeChatRequest.
+const streamCacheKeyPrefix = "stream:"
+
+// cachedStreamResponse stores a streamed chat completion as the sequence of
+// content deltas actually delivered, so a later cache hit can reconstruct
+// the SSE frames instead of replaying a single whole-body response.
+type cachedStreamResponse struct {
+	Deltas           []string `json:"deltas"`
+	FinishReason     string   `json:"finish_reason"`
+	PromptTokens     int      `json:"prompt_tokens"`
+	CompletionTokens int      `json:"completion_tokens"`
+}
+
+// wantsCache reports whether this request should go through the
+// deterministic cache. `Temperature` on ChatCompletionRequest/
+// CompletionRequest is a plain float64, so an omitted temperature is
+// indistinguishable from an explicit 0 - treating either as "deterministic"
+// would silently cache (and charge the cache-hit discount rate for) the
+// overwhelming majority of ordinary, non-deterministic requests. Only an
+// explicit `x-cache: true` opts a request in.
+func wantsCache(c *gin.Context) bool {
+	return c.GetHeader("x-cache") == "true"
+}
+
+// normalizeChatRequest serializes the model, messages, tools,
+// response_format and seed of a chat request, for hashing into the cache
+// key. Every other field is left out (stream, user, and also sampling
+// fields such as temperature), so requests that differ only in those share
+// a cache entry.
+func normalizeChatRequest(req *types.ChatCompletionRequest) string {
+	normalized, _ := json.Marshal(struct {
+		Model          string                        `json:"model"`
+		Messages       []types.ChatCompletionMessage `json:"messages"`
+		Tools          interface{}                   `json:"tools,omitempty"`
+		ResponseFormat interface{}                   `json:"response_format,omitempty"`
+		Seed           interface{}                   `json:"seed,omitempty"`
+	}{
+		Model:          req.Model,
+		Messages:       req.Messages,
+		Tools:          req.Tools,
+		ResponseFormat: req.ResponseFormat,
+		Seed:           req.Seed,
+	})
+	return string(normalized)
+}
+
+// normalizeCompletionRequest serializes the model and prompt of a legacy
+// completion request, for hashing into the cache key; other fields,
+// temperature included, are left out.
+func normalizeCompletionRequest(req *types.CompletionRequest) string {
+	normalized, _ := json.Marshal(struct {
+		Model  string `json:"model"`
+		Prompt string `json:"prompt"`
+	}{
+		Model:  req.Model,
+		Prompt: req.Prompt,
+	})
+	return string(normalized)
+}
+
+// normalizeEmbeddingRequest serializes the parts of an embeddings request
+// that determine its output, for hashing into the cache key.
+func normalizeEmbeddingRequest(req *types.EmbeddingRequest) string {
+	normalized, _ := json.Marshal(struct {
+		Model string `json:"model"`
+		Input string `json:"input"`
+	}{
+		Model: req.Model,
+		Input: req.Input,
+	})
+	return string(normalized)
+}
+
+// cacheHit replays the cached response stored under key onto c, returning
+// the discounted usage. The second return value is false on a miss or a
+// corrupt entry (which is evicted so the next request repopulates it).
+func cacheHit(c *gin.Context, key string) (*types.Usage, bool) {
+	raw, ok := common.DefaultCacheStore.Get(key)
+	if !ok {
+		return nil, false
+	}
+	var entry cachedRelayResponse
+	if err := json.Unmarshal(raw, &entry); err != nil {
+		common.DefaultCacheStore.Delete(key)
+		return nil, false
+	}
+
+	c.Data(http.StatusOK, "application/json", entry.Body)
+	common.LogInfo(c.Request.Context(), "relay cache hit, cache_hit=true")
+
+	discountedPromptTokens := int(float64(entry.PromptTokens) * cacheDiscountRate)
+	discountedCompletionTokens := int(float64(entry.CompletionTokens) * cacheDiscountRate)
+	return &types.Usage{
+		PromptTokens:     discountedPromptTokens,
+		CompletionTokens: discountedCompletionTokens,
+		TotalTokens:      discountedPromptTokens + discountedCompletionTokens,
+	}, true
+}
+
+// storeCacheEntry records a fresh response body and its real (undiscounted)
+// usage under key, so the next matching request can be served from cache.
+func storeCacheEntry(key string, body []byte, usage *types.Usage) {
+	entry := cachedRelayResponse{Body: body}
+	if usage != nil {
+		entry.PromptTokens = usage.PromptTokens
+		entry.CompletionTokens = usage.CompletionTokens
+	}
+	if raw, err := json.Marshal(entry); err == nil {
+		common.DefaultCacheStore.Set(key, raw, cacheTTL)
+	}
+}
+
+// streamCacheHit replays a cached streamed chat completion onto c by
+// reconstructing SSE frames from the stored delta list, returning the
+// discounted usage. The second return value is false on a miss or a
+// corrupt entry (which is evicted so the next request repopulates it).
+func streamCacheHit(c *gin.Context, key string) (*types.Usage, bool) {
+	raw, ok := common.DefaultCacheStore.Get(streamCacheKeyPrefix + key)
+	if !ok {
+		return nil, false
+	}
+	var entry cachedStreamResponse
+	if err := json.Unmarshal(raw, &entry); err != nil {
+		common.DefaultCacheStore.Delete(streamCacheKeyPrefix + key)
+		return nil, false
+	}
+
+	replaySSEFrames(c, entry.Deltas, entry.FinishReason)
+	common.LogInfo(c.Request.Context(), "relay cache hit, cache_hit=true")
+
+	discountedPromptTokens := int(float64(entry.PromptTokens) * cacheDiscountRate)
+	discountedCompletionTokens := int(float64(entry.CompletionTokens) * cacheDiscountRate)
+	return &types.Usage{
+		PromptTokens:     discountedPromptTokens,
+		CompletionTokens: discountedCompletionTokens,
+		TotalTokens:      discountedPromptTokens + discountedCompletionTokens,
+	}, true
+}
+
+// replaySSEFrames writes one SSE chunk per stored delta, followed by a
+// closing finish_reason chunk and the [DONE] sentinel, mirroring the chunk
+// shape providers_base.StreamMultiplexer parses on the way in.
+func replaySSEFrames(c *gin.Context, deltas []string, finishReason string) {
+	c.Writer.Header().Set("Content-Type", "text/event-stream")
+	for _, delta := range deltas {
+		writeSSEChunk(c, delta, "")
+	}
+	writeSSEChunk(c, "", finishReason)
+	c.Writer.Write([]byte("data: [DONE]\n\n"))
+	if flusher, ok := c.Writer.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func writeSSEChunk(c *gin.Context, content string, finishReason string) {
+	chunk := map[string]interface{}{
+		"choices": []map[string]interface{}{{
+			"delta":         map[string]string{"content": content},
+			"finish_reason": finishReason,
+		}},
+	}
+	body, err := json.Marshal(chunk)
+	if err != nil {
+		return
+	}
+	c.Writer.Write([]byte("data: " + string(body) + "\n\n"))
+}
+
+// storeStreamCacheEntry records a fresh streamed chat completion's delta
+// list and finish reason under key, so the next matching request can be
+// replayed from cache instead of going through the provider again.
+func storeStreamCacheEntry(key string, deltas []string, finishReason string, promptTokens int, completionTokens int) {
+	entry := cachedStreamResponse{
+		Deltas:           deltas,
+		FinishReason:     finishReason,
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+	}
+	if raw, err := json.Marshal(entry); err == nil {
+		common.DefaultCacheStore.Set(streamCacheKeyPrefix+key, raw, cacheTTL)
+	}
+}
+
+// newCacheSubContext builds a recorder-backed context that shares the
+// inbound request but captures the response bytes instead of writing them
+// to the real client twice.
+func newCacheSubContext(c *gin.Context, channelType int) (*gin.Context, *httptest.ResponseRecorder) {
+	recorder := httptest.NewRecorder()
+	subCtx, _ := gin.CreateTestContext(recorder)
+	subCtx.Request = c.Request
+	subCtx.Set("channel", channelType)
+	subCtx.Set("channel_id", c.GetInt("channel_id"))
+	return subCtx, recorder
+}
+
+// handleChatCompletionsCached runs a non-streaming chat completion request
+// through the deterministic cache: on a hit it replays the stored body and
+// charges cacheDiscountRate of the normal quota; on a miss it resolves a
+// fresh provider bound to a recorder-backed context (so the response bytes
+// can be captured without being written twice), forwards the response to
+// the client, stores it, and returns the real usage.
+func handleChatCompletionsCached(c *gin.Context, channelType int, chatRequest *types.ChatCompletionRequest, isModelMapped bool, promptTokens int, group string) (*types.Usage, *types.OpenAIErrorWithStatusCode) {
+	key := common.ComputeRelayCacheKey(group, chatRequest.Model, normalizeChatRequest(chatRequest))
+	if usage, ok := cacheHit(c, key); ok {
+		return usage, nil
+	}
+
+	subCtx, recorder := newCacheSubContext(c, channelType)
+	subProvider := providers.GetProvider(channelType, subCtx)
+	chatProvider, ok := subProvider.(providers_base.ChatInterface)
+	if subProvider == nil || !ok {
+		return nil, common.ErrorWrapper(errors.New("channel not implemented"), "channel_not_implemented", http.StatusNotImplemented)
+	}
+
+	usage, apiErr := chatProvider.ChatAction(chatRequest, isModelMapped, promptTokens)
+	c.Data(recorder.Code, recorder.Header().Get("Content-Type"), recorder.Body.Bytes())
+	if apiErr != nil {
+		return usage, apiErr
+	}
+
+	storeCacheEntry(key, recorder.Body.Bytes(), usage)
+	return usage, nil
+}
+
+// handleStreamingChatCompletionsCached is handleStreamingChatCompletions'
+// cache-aware counterpart: on a hit it reconstructs SSE frames from the
+// stored delta list and skips provider dispatch entirely; on a miss it makes
+// a single ChatAction call that streams straight to the client, then stores
+// the deltas providers_base.StreamMultiplexer recorded for the next matching
+// request. Unlike handleStreamingChatCompletions it does not fail over to
+// another channel and applies no output filter. Unlike the non-streaming cached
+// handlers it writes directly to c rather than a recorder-backed
+// sub-context, since a stream has to reach the client as it arrives rather
+// than being replayed afterwards.
+func handleStreamingChatCompletionsCached(c *gin.Context, chatProvider providers_base.ChatInterface, chatRequest *types.ChatCompletionRequest, isModelMapped bool, promptTokens int, group string) (*types.Usage, *types.OpenAIErrorWithStatusCode) {
+	key := common.ComputeRelayCacheKey(group, chatRequest.Model, normalizeChatRequest(chatRequest))
+	if usage, ok := streamCacheHit(c, key); ok {
+		return usage, nil
+	}
+
+	mux := providers_base.NewStreamMultiplexer(c)
+	usage, apiErr := chatProvider.ChatAction(chatRequest, isModelMapped, promptTokens)
+	if apiErr != nil {
+		return usage, apiErr
+	}
+
+	completionTokens := mux.BilledCompletionTokens(chatRequest.Model)
+	if usage != nil {
+		completionTokens = usage.CompletionTokens
+	}
+	storeStreamCacheEntry(key, mux.Deltas(), mux.FinishReason(), promptTokens, completionTokens)
+	return usage, nil
+}
+
+// handleCompletionsCached is the handleChatCompletionsCached equivalent for
+// the legacy completions endpoint.
+func handleCompletionsCached(c *gin.Context, channelType int, completionRequest *types.CompletionRequest, isModelMapped bool, promptTokens int, group string) (*types.Usage, *types.OpenAIErrorWithStatusCode) {
+	key := common.ComputeRelayCacheKey(group, completionRequest.Model, normalizeCompletionRequest(completionRequest))
+	if usage, ok := cacheHit(c, key); ok {
+		return usage, nil
+	}
+
+	subCtx, recorder := newCacheSubContext(c, channelType)
+	subProvider := providers.GetProvider(channelType, subCtx)
+	completionProvider, ok := subProvider.(providers_base.CompletionInterface)
+	if subProvider == nil || !ok {
+		return nil, common.ErrorWrapper(errors.New("channel not implemented"), "channel_not_implemented", http.StatusNotImplemented)
+	}
+
+	usage, apiErr := completionProvider.CompleteAction(completionRequest, isModelMapped, promptTokens)
+	c.Data(recorder.Code, recorder.Header().Get("Content-Type"), recorder.Body.Bytes())
+	if apiErr != nil {
+		return usage, apiErr
+	}
+
+	storeCacheEntry(key, recorder.Body.Bytes(), usage)
+	return usage, nil
+}
+
+// handleEmbeddingsCached is the handleChatCompletionsCached equivalent for
+// the embeddings endpoint. Embeddings have no temperature knob, so they are
+// always deterministic and eligible for the cache.
+func handleEmbeddingsCached(c *gin.Context, channelType int, embeddingsRequest *types.EmbeddingRequest, isModelMapped bool, promptTokens int, group string) (*types.Usage, *types.OpenAIErrorWithStatusCode) {
+	key := common.ComputeRelayCacheKey(group, embeddingsRequest.Model, normalizeEmbeddingRequest(embeddingsRequest))
+	if usage, ok := cacheHit(c, key); ok {
+		return usage, nil
+	}
+
+	subCtx, recorder := newCacheSubContext(c, channelType)
+	subProvider := providers.GetProvider(channelType, subCtx)
+	embeddingsProvider, ok := subProvider.(providers_base.EmbeddingsInterface)
+	if subProvider == nil || !ok {
+		return nil, common.ErrorWrapper(errors.New("channel not implemented"), "channel_not_implemented", http.StatusNotImplemented)
+	}
+
+	usage, apiErr := embeddingsProvider.EmbeddingsAction(embeddingsRequest, isModelMapped, promptTokens)
+	c.Data(recorder.Code, recorder.Header().Get("Content-Type"), recorder.Body.Bytes())
+	if apiErr != nil {
+		return usage, apiErr
+	}
+
+	storeCacheEntry(key, recorder.Body.Bytes(), usage)
+	return usage, nil
+}
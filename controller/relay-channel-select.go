@@ -0,0 +1,24 @@
+package controller
+
+import (
+	"one-api/common"
+	"one-api/model"
+)
+
+// nextCandidateChannelId picks the next channel of channelType to retry
+// against, excluding every id already tried, weighted by priority (the
+// order model.ListChannelIdsByType already returns) and skipping any that
+// common.IsChannelHealthy has marked unhealthy. It returns false once no
+// candidate remains, so the caller can give up rather than looping forever.
+func nextCandidateChannelId(channelType int, excluded []int) (int, bool) {
+	candidates, err := model.ListChannelIdsByType(channelType, excluded)
+	if err != nil {
+		return 0, false
+	}
+	for _, id := range candidates {
+		if common.IsChannelHealthy(id) {
+			return id, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,246 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/model"
+	"one-api/providers"
+	providers_base "one-api/providers/base"
+	"one-api/types"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newModerationSubContext builds a recorder-backed context for a
+// moderation-channel filter's own ModerationAction call, the same
+// recorder-backed-subcontext pattern newCacheSubContext and fanOutModeration
+// use to invoke a provider without writing its response to the real client.
+func newModerationSubContext(channelId int) (*httptest.ResponseRecorder, *gin.Context) {
+	recorder := httptest.NewRecorder()
+	subCtx, _ := gin.CreateTestContext(recorder)
+	subCtx.Request, _ = http.NewRequest(http.MethodPost, "/", nil)
+	subCtx.Set("channel_id", channelId)
+	return recorder, subCtx
+}
+
+// regexContentFilter flags any input that matches a configured regular
+// expression, e.g. a blocklist of phrases an admin never wants relayed.
+type regexContentFilter struct {
+	pattern *regexp.Regexp
+}
+
+func (f *regexContentFilter) Name() string { return "regex" }
+
+func (f *regexContentFilter) Check(text string) (*providers_base.ContentFilterResult, error) {
+	result := &providers_base.ContentFilterResult{}
+	if f.pattern.MatchString(text) {
+		result.Hate = providers_base.ContentFilterCategoryResult{
+			Filtered: true,
+			Severity: providers_base.ContentFilterSeverityHigh,
+		}
+	}
+	return result, nil
+}
+
+// blocklistContentFilter flags input containing any of a fixed set of
+// case-insensitive substrings, stored as a comma-separated pattern.
+type blocklistContentFilter struct {
+	terms []string
+}
+
+func (f *blocklistContentFilter) Name() string { return "blocklist" }
+
+func (f *blocklistContentFilter) Check(text string) (*providers_base.ContentFilterResult, error) {
+	result := &providers_base.ContentFilterResult{}
+	lower := strings.ToLower(text)
+	for _, term := range f.terms {
+		if term != "" && strings.Contains(lower, term) {
+			result.Hate = providers_base.ContentFilterCategoryResult{
+				Filtered: true,
+				Severity: providers_base.ContentFilterSeverityMedium,
+			}
+			break
+		}
+	}
+	return result, nil
+}
+
+// moderationModel is the model every moderation-channel filter check
+// requests (no model mapping is applied to it), matching the default
+// handleModerations falls back to.
+const moderationModel = "text-moderation-latest"
+
+// moderationChannelFilter flags input by running it through a moderation
+// model hosted on a configured channel. It calls ModerationAction directly
+// rather than handleModerations: handleModerations reads its request body
+// and quota reservation from the real gin.Context, and a filter check must
+// do neither, since it runs ahead of (and independently from) the actual
+// relay request's own quota accounting.
+type moderationChannelFilter struct {
+	channelId int
+}
+
+func (f *moderationChannelFilter) Name() string { return "moderation_channel" }
+
+func (f *moderationChannelFilter) Check(text string) (*providers_base.ContentFilterResult, error) {
+	channelType, err := model.GetChannelTypeById(f.channelId)
+	if err != nil {
+		return nil, err
+	}
+
+	recorder, subCtx := newModerationSubContext(f.channelId)
+	subProvider := providers.GetProvider(channelType, subCtx)
+	moderationProvider, ok := subProvider.(providers_base.ModerationInterface)
+	if subProvider == nil || !ok {
+		return nil, errors.New("moderation channel does not implement moderations")
+	}
+
+	promptTokens := common.CountTokenInput(text, moderationModel)
+	_, apiErr := moderationProvider.ModerationAction(&types.ModerationRequest{
+		Model: moderationModel,
+		Input: text,
+	}, false, promptTokens)
+	if apiErr != nil {
+		return nil, errors.New(apiErr.Error.Message)
+	}
+
+	var moderationResp struct {
+		Results []types.ModerationResultItem `json:"results"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &moderationResp); err != nil || len(moderationResp.Results) == 0 {
+		return &providers_base.ContentFilterResult{}, nil
+	}
+
+	result := &providers_base.ContentFilterResult{}
+	if moderationResp.Results[0].Flagged {
+		result.Hate = providers_base.ContentFilterCategoryResult{
+			Filtered: true,
+			Severity: providers_base.ContentFilterSeverityHigh,
+		}
+	}
+	return result, nil
+}
+
+// buildContentFilter turns a stored model.ContentFilter config into a
+// runnable providers_base.ContentFilterInterface.
+func buildContentFilter(cf *model.ContentFilter) providers_base.ContentFilterInterface {
+	switch cf.Type {
+	case model.ContentFilterTypeRegex:
+		pattern, err := regexp.Compile(cf.Pattern)
+		if err != nil {
+			return nil
+		}
+		return &regexContentFilter{pattern: pattern}
+	case model.ContentFilterTypeBlocklist:
+		return &blocklistContentFilter{terms: strings.Split(strings.ToLower(cf.Pattern), ",")}
+	case model.ContentFilterTypeModerationChannel:
+		if cf.ModerationChannelId == 0 {
+			return nil
+		}
+		return &moderationChannelFilter{channelId: cf.ModerationChannelId}
+	default:
+		return nil
+	}
+}
+
+// contentFilterError carries the Azure-style prompt_filter_results payload
+// through the existing common.ErrorWrapper(err, code, status) contract: its
+// Error() renders as the JSON body so the client sees both a human message
+// and the structured filter results without requiring a new field on
+// types.OpenAIErrorWithStatusCode.
+type contentFilterError struct {
+	Message             string                      `json:"message"`
+	PromptFilterResults []contentFilterPromptResult `json:"prompt_filter_results"`
+}
+
+type contentFilterPromptResult struct {
+	PromptIndex          int                                 `json:"prompt_index"`
+	ContentFilterResults *providers_base.ContentFilterResult `json:"content_filter_results"`
+	Source               string                              `json:"source"`
+}
+
+func (e *contentFilterError) Error() string {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return e.Message
+	}
+	return string(body)
+}
+
+// loadContentFilters fetches and builds every enabled filter configured for
+// a token/channel once, so a caller that needs to check many pieces of text
+// (e.g. one per streamed delta) doesn't refetch and recompile them each
+// time.
+func loadContentFilters(tokenId int, channelId int) []providers_base.ContentFilterInterface {
+	cfs, err := model.GetContentFiltersForToken(tokenId, channelId)
+	if err != nil {
+		return nil
+	}
+	filters := make([]providers_base.ContentFilterInterface, 0, len(cfs))
+	for _, cf := range cfs {
+		if filter := buildContentFilter(cf); filter != nil {
+			filters = append(filters, filter)
+		}
+	}
+	return filters
+}
+
+// checkFilters runs text through every already-built filter and returns a
+// structured error the moment one flags it; direction ("prompt" or
+// "completion") is recorded on the error so a caller can tell which side of
+// the relay tripped it. A filter that errors out (e.g. a moderation-channel
+// request timing out) fails closed rather than being skipped: a filter an
+// admin configured is a safety control, and silently letting unchecked
+// content through whenever the check itself fails would defeat it.
+func checkFilters(filters []providers_base.ContentFilterInterface, text string, direction string) *types.OpenAIErrorWithStatusCode {
+	for _, filter := range filters {
+		result, err := filter.Check(text)
+		if err != nil {
+			common.SysError("content filter " + filter.Name() + " check failed, failing closed: " + err.Error())
+			return common.ErrorWrapper(&contentFilterError{
+				Message: "the content could not be checked against a configured content filter",
+				PromptFilterResults: []contentFilterPromptResult{{
+					PromptIndex: 0,
+					Source:      direction + ":" + filter.Name(),
+				}},
+			}, "content_filter_check_failed", http.StatusServiceUnavailable)
+		}
+		if result.Flagged() {
+			return common.ErrorWrapper(&contentFilterError{
+				Message: "the content was flagged by a content filter",
+				PromptFilterResults: []contentFilterPromptResult{{
+					PromptIndex:          0,
+					ContentFilterResults: result,
+					Source:               direction + ":" + filter.Name(),
+				}},
+			}, "content_filter_triggered", http.StatusBadRequest)
+		}
+	}
+	return nil
+}
+
+// runInputContentFilters checks text submitted by the caller before it is
+// ever sent upstream.
+func runInputContentFilters(tokenId int, channelId int, text string) *types.OpenAIErrorWithStatusCode {
+	return checkFilters(loadContentFilters(tokenId, channelId), text, "prompt")
+}
+
+// runOutputContentFilters checks text a provider generated before it is
+// forwarded to the caller.
+func runOutputContentFilters(tokenId int, channelId int, text string) *types.OpenAIErrorWithStatusCode {
+	return checkFilters(loadContentFilters(tokenId, channelId), text, "completion")
+}
+
+// hasContentFilters reports whether any enabled filter exists for this
+// token/channel, so callers can skip the extra work an output filter check
+// requires (replaying a non-streaming response through a recorder, or
+// installing a per-delta callback on the stream multiplexer) when nothing
+// is configured.
+func hasContentFilters(tokenId int, channelId int) bool {
+	return len(loadContentFilters(tokenId, channelId)) > 0
+}
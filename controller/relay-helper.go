@@ -2,6 +2,7 @@ package controller
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -22,6 +23,20 @@ func relayHelper(c *gin.Context, relayMode int) *types.OpenAIErrorWithStatusCode
 	userId := c.GetInt("id")
 	group := c.GetString("group")
 
+	if isAsyncRequest(c) && asyncCapableRelayModes[relayMode] {
+		return handleAsyncRelay(c, relayMode, channelType, channelId, group)
+	}
+
+	if relayMode == common.RelayModeChatCompletions && c.GetHeader("x-auto-execute") == "true" {
+		var probe struct {
+			Tools      []json.RawMessage `json:"tools"`
+			ToolChoice json.RawMessage   `json:"tool_choice"`
+		}
+		if common.UnmarshalBodyReusable(c, &probe) == nil && len(probe.Tools) > 0 && string(probe.ToolChoice) == `"auto"` {
+			relayMode = common.RelayModeChatCompletionsAgent
+		}
+	}
+
 	// 获取 Provider
 	provider := providers.GetProvider(channelType, c)
 	if provider == nil {
@@ -51,6 +66,8 @@ func relayHelper(c *gin.Context, relayMode int) *types.OpenAIErrorWithStatusCode
 	switch relayMode {
 	case common.RelayModeChatCompletions:
 		usage, openAIErrorWithStatusCode = handleChatCompletions(c, provider, modelMap, quotaInfo, group)
+	case common.RelayModeChatCompletionsAgent:
+		usage, openAIErrorWithStatusCode = handleChatCompletionsAgent(c, provider, modelMap, quotaInfo, group)
 	case common.RelayModeCompletions:
 		usage, openAIErrorWithStatusCode = handleCompletions(c, provider, modelMap, quotaInfo, group)
 	case common.RelayModeEmbeddings:
@@ -121,6 +138,13 @@ func handleChatCompletions(c *gin.Context, provider providers_base.ProviderInter
 		chatRequest.Model = modelMap[chatRequest.Model]
 		isModelMapped = true
 	}
+
+	for _, message := range chatRequest.Messages {
+		if filterErr := runInputContentFilters(c.GetInt("token_id"), c.GetInt("channel_id"), message.Content); filterErr != nil {
+			return nil, filterErr
+		}
+	}
+
 	promptTokens := common.CountTokenMessages(chatRequest.Messages, chatRequest.Model)
 
 	quotaInfo.modelName = chatRequest.Model
@@ -130,9 +154,151 @@ func handleChatCompletions(c *gin.Context, provider providers_base.ProviderInter
 	if quota_err != nil {
 		return nil, quota_err
 	}
+
+	if chatRequest.Stream {
+		if wantsCache(c) {
+			return handleStreamingChatCompletionsCached(c, chatProvider, &chatRequest, isModelMapped, promptTokens, group)
+		}
+		return handleStreamingChatCompletions(c, chatProvider, &chatRequest, isModelMapped, promptTokens)
+	}
+
+	if wantsCache(c) {
+		return handleChatCompletionsCached(c, c.GetInt("channel"), &chatRequest, isModelMapped, promptTokens, group)
+	}
+
+	if hasContentFilters(c.GetInt("token_id"), c.GetInt("channel_id")) {
+		return filterChatCompletionOutput(c, c.GetInt("channel"), &chatRequest, isModelMapped, promptTokens)
+	}
+
 	return chatProvider.ChatAction(&chatRequest, isModelMapped, promptTokens)
 }
 
+// filterChatCompletionOutput replays a non-streaming chat completion through
+// a recorder-backed provider call (the same pattern handleChatCompletionsCached
+// uses) so the assistant's message can be checked against the token/channel's
+// output filters before any bytes reach the client.
+func filterChatCompletionOutput(c *gin.Context, channelType int, chatRequest *types.ChatCompletionRequest, isModelMapped bool, promptTokens int) (*types.Usage, *types.OpenAIErrorWithStatusCode) {
+	subCtx, recorder := newCacheSubContext(c, channelType)
+	subProvider := providers.GetProvider(channelType, subCtx)
+	chatProvider, ok := subProvider.(providers_base.ChatInterface)
+	if subProvider == nil || !ok {
+		return nil, common.ErrorWrapper(errors.New("channel not implemented"), "channel_not_implemented", http.StatusNotImplemented)
+	}
+
+	usage, apiErr := chatProvider.ChatAction(chatRequest, isModelMapped, promptTokens)
+	if apiErr != nil {
+		return usage, apiErr
+	}
+
+	var resp struct {
+		Choices []struct {
+			Message types.ChatCompletionMessage `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &resp); err == nil {
+		for _, choice := range resp.Choices {
+			if filterErr := runOutputContentFilters(c.GetInt("token_id"), c.GetInt("channel_id"), choice.Message.Content); filterErr != nil {
+				return nil, filterErr
+			}
+		}
+	}
+
+	c.Data(recorder.Code, recorder.Header().Get("Content-Type"), recorder.Body.Bytes())
+	return usage, nil
+}
+
+// handleStreamingChatCompletions wraps the provider's SSE stream in a
+// providers_base.StreamMultiplexer: if the upstream connection drops or
+// errors partway through, it reconstructs the request with the already
+// delivered assistant text as a prefill and retries against the next
+// enabled channel of the same channel type (see nextCandidateChannelId),
+// governed by the originating channel's common.RelayFailoverPolicy
+// (common.DefaultRelayFailoverPolicy if it never configured its own).
+// Whether that channel serves the requested model is not checked. Only the
+// tokens actually forwarded to the client are reflected in the returned
+// usage.
+func handleStreamingChatCompletions(c *gin.Context, chatProvider providers_base.ChatInterface, chatRequest *types.ChatCompletionRequest, isModelMapped bool, promptTokens int) (*types.Usage, *types.OpenAIErrorWithStatusCode) {
+	policy := common.RelayFailoverPolicyForChannel(c.GetInt("channel_id"))
+	mux := providers_base.NewStreamMultiplexer(c)
+	channelType := c.GetInt("channel")
+	var excludedChannelIds []int
+	prefilled := false
+
+	tokenId, channelId := c.GetInt("token_id"), c.GetInt("channel_id")
+	if outputFilters := loadContentFilters(tokenId, channelId); len(outputFilters) > 0 {
+		mux.SetOutputFilter(func(delta string) bool {
+			return checkFilters(outputFilters, delta, "completion") != nil
+		})
+	}
+
+	for {
+		usage, apiErr := chatProvider.ChatAction(chatRequest, isModelMapped, promptTokens)
+		if apiErr == nil {
+			// After a failover the last provider's usage covers only its own
+			// continuation, so bill everything delivered across attempts.
+			if mux.Blocked() || mux.Attempt() > 0 {
+				completionTokens := mux.BilledCompletionTokens(chatRequest.Model)
+				return &types.Usage{
+					PromptTokens:     promptTokens,
+					CompletionTokens: completionTokens,
+					TotalTokens:      promptTokens + completionTokens,
+				}, nil
+			}
+			return usage, nil
+		}
+
+		if mux.FinishReasonSeen() || !policy.ShouldRetry(mux.Attempt(), apiErr.StatusCode) {
+			if mux.DeliveredTokens() > 0 {
+				// The client already received a partial response; bill for
+				// what was actually delivered instead of refunding the
+				// whole pre-consumed quota.
+				completionTokens := mux.BilledCompletionTokens(chatRequest.Model)
+				return &types.Usage{
+					PromptTokens:     promptTokens,
+					CompletionTokens: completionTokens,
+					TotalTokens:      promptTokens + completionTokens,
+				}, nil
+			}
+			return usage, apiErr
+		}
+
+		excludedChannelIds = append(excludedChannelIds, c.GetInt("channel_id"))
+		nextChannelId, ok := nextCandidateChannelId(channelType, excludedChannelIds)
+		if !ok {
+			return usage, apiErr
+		}
+		c.Set("channel_id", nextChannelId)
+
+		nextProvider := providers.GetProvider(channelType, c)
+		if nextProvider == nil {
+			return usage, apiErr
+		}
+		nextChatProvider, ok := nextProvider.(providers_base.ChatInterface)
+		if !ok {
+			return usage, apiErr
+		}
+		chatProvider = nextChatProvider
+		mux.NextAttempt()
+
+		partialToolCalls := mux.PartialToolCalls()
+		if mux.PartialAssistantText() != "" || len(partialToolCalls) > 0 {
+			prefill := types.ChatCompletionMessage{
+				Role:      "assistant",
+				Content:   mux.PartialAssistantText(),
+				ToolCalls: partialToolCalls,
+			}
+			// PartialAssistantText accumulates across attempts, so a later
+			// failover replaces the earlier prefill instead of adding a second.
+			if prefilled {
+				chatRequest.Messages[len(chatRequest.Messages)-1] = prefill
+			} else {
+				chatRequest.Messages = append(chatRequest.Messages, prefill)
+				prefilled = true
+			}
+		}
+	}
+}
+
 func handleCompletions(c *gin.Context, provider providers_base.ProviderInterface, modelMap map[string]string, quotaInfo *QuotaInfo, group string) (*types.Usage, *types.OpenAIErrorWithStatusCode) {
 	var completionRequest types.CompletionRequest
 	isModelMapped := false
@@ -154,6 +320,11 @@ func handleCompletions(c *gin.Context, provider providers_base.ProviderInterface
 		completionRequest.Model = modelMap[completionRequest.Model]
 		isModelMapped = true
 	}
+
+	if filterErr := runInputContentFilters(c.GetInt("token_id"), c.GetInt("channel_id"), completionRequest.Prompt); filterErr != nil {
+		return nil, filterErr
+	}
+
 	promptTokens := common.CountTokenInput(completionRequest.Prompt, completionRequest.Model)
 
 	quotaInfo.modelName = completionRequest.Model
@@ -163,9 +334,51 @@ func handleCompletions(c *gin.Context, provider providers_base.ProviderInterface
 	if quota_err != nil {
 		return nil, quota_err
 	}
+
+	if wantsCache(c) {
+		return handleCompletionsCached(c, c.GetInt("channel"), &completionRequest, isModelMapped, promptTokens, group)
+	}
+
+	if hasContentFilters(c.GetInt("token_id"), c.GetInt("channel_id")) {
+		return filterCompletionOutput(c, c.GetInt("channel"), &completionRequest, isModelMapped, promptTokens)
+	}
+
 	return completionProvider.CompleteAction(&completionRequest, isModelMapped, promptTokens)
 }
 
+// filterCompletionOutput is filterChatCompletionOutput's equivalent for the
+// legacy completions endpoint, checking each choice's text instead of a
+// chat message's content.
+func filterCompletionOutput(c *gin.Context, channelType int, completionRequest *types.CompletionRequest, isModelMapped bool, promptTokens int) (*types.Usage, *types.OpenAIErrorWithStatusCode) {
+	subCtx, recorder := newCacheSubContext(c, channelType)
+	subProvider := providers.GetProvider(channelType, subCtx)
+	completionProvider, ok := subProvider.(providers_base.CompletionInterface)
+	if subProvider == nil || !ok {
+		return nil, common.ErrorWrapper(errors.New("channel not implemented"), "channel_not_implemented", http.StatusNotImplemented)
+	}
+
+	usage, apiErr := completionProvider.CompleteAction(completionRequest, isModelMapped, promptTokens)
+	if apiErr != nil {
+		return usage, apiErr
+	}
+
+	var resp struct {
+		Choices []struct {
+			Text string `json:"text"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &resp); err == nil {
+		for _, choice := range resp.Choices {
+			if filterErr := runOutputContentFilters(c.GetInt("token_id"), c.GetInt("channel_id"), choice.Text); filterErr != nil {
+				return nil, filterErr
+			}
+		}
+	}
+
+	c.Data(recorder.Code, recorder.Header().Get("Content-Type"), recorder.Body.Bytes())
+	return usage, nil
+}
+
 func handleEmbeddings(c *gin.Context, provider providers_base.ProviderInterface, modelMap map[string]string, quotaInfo *QuotaInfo, group string) (*types.Usage, *types.OpenAIErrorWithStatusCode) {
 	var embeddingsRequest types.EmbeddingRequest
 	isModelMapped := false
@@ -174,6 +387,10 @@ func handleEmbeddings(c *gin.Context, provider providers_base.ProviderInterface,
 		return nil, common.ErrorWrapper(errors.New("channel not implemented"), "channel_not_implemented", http.StatusNotImplemented)
 	}
 
+	if isArrayInput(c) {
+		return handleEmbeddingsBatch(c, embeddingsProvider, modelMap, quotaInfo, group)
+	}
+
 	err := common.UnmarshalBodyReusable(c, &embeddingsRequest)
 	if err != nil {
 		return nil, common.ErrorWrapper(err, "bind_request_body_failed", http.StatusBadRequest)
@@ -187,6 +404,11 @@ func handleEmbeddings(c *gin.Context, provider providers_base.ProviderInterface,
 		embeddingsRequest.Model = modelMap[embeddingsRequest.Model]
 		isModelMapped = true
 	}
+
+	if filterErr := runInputContentFilters(c.GetInt("token_id"), c.GetInt("channel_id"), embeddingsRequest.Input); filterErr != nil {
+		return nil, filterErr
+	}
+
 	promptTokens := common.CountTokenInput(embeddingsRequest.Input, embeddingsRequest.Model)
 
 	quotaInfo.modelName = embeddingsRequest.Model
@@ -196,6 +418,11 @@ func handleEmbeddings(c *gin.Context, provider providers_base.ProviderInterface,
 	if quota_err != nil {
 		return nil, quota_err
 	}
+
+	if wantsCache(c) {
+		return handleEmbeddingsCached(c, c.GetInt("channel"), &embeddingsRequest, isModelMapped, promptTokens, group)
+	}
+
 	return embeddingsProvider.EmbeddingsAction(&embeddingsRequest, isModelMapped, promptTokens)
 }
 
@@ -207,6 +434,10 @@ func handleModerations(c *gin.Context, provider providers_base.ProviderInterface
 		return nil, common.ErrorWrapper(errors.New("channel not implemented"), "channel_not_implemented", http.StatusNotImplemented)
 	}
 
+	if isArrayInput(c) {
+		return handleModerationsBatch(c, moderationProvider, modelMap, quotaInfo, group)
+	}
+
 	err := common.UnmarshalBodyReusable(c, &moderationRequest)
 	if err != nil {
 		return nil, common.ErrorWrapper(err, "bind_request_body_failed", http.StatusBadRequest)
@@ -257,6 +488,11 @@ func handleSpeech(c *gin.Context, provider providers_base.ProviderInterface, mod
 		speechRequest.Model = modelMap[speechRequest.Model]
 		isModelMapped = true
 	}
+
+	if filterErr := runInputContentFilters(c.GetInt("token_id"), c.GetInt("channel_id"), speechRequest.Input); filterErr != nil {
+		return nil, filterErr
+	}
+
 	promptTokens := len(speechRequest.Input)
 
 	quotaInfo.modelName = speechRequest.Model
@@ -366,6 +602,11 @@ func handleImageGenerations(c *gin.Context, provider providers_base.ProviderInte
 		imageRequest.Model = modelMap[imageRequest.Model]
 		isModelMapped = true
 	}
+
+	if filterErr := runInputContentFilters(c.GetInt("token_id"), c.GetInt("channel_id"), imageRequest.Prompt); filterErr != nil {
+		return nil, filterErr
+	}
+
 	promptTokens, err := common.CountTokenImage(imageRequest)
 	if err != nil {
 		return nil, common.ErrorWrapper(err, "count_token_image_failed", http.StatusInternalServerError)
@@ -416,6 +657,13 @@ func handleImageEdits(c *gin.Context, provider providers_base.ProviderInterface,
 		imageEditRequest.Model = modelMap[imageEditRequest.Model]
 		isModelMapped = true
 	}
+
+	if imageType == "edit" && imageEditRequest.Prompt != "" {
+		if filterErr := runInputContentFilters(c.GetInt("token_id"), c.GetInt("channel_id"), imageEditRequest.Prompt); filterErr != nil {
+			return nil, filterErr
+		}
+	}
+
 	promptTokens, err := common.CountTokenImage(imageEditRequest)
 	if err != nil {
 		return nil, common.ErrorWrapper(err, "count_token_image_failed", http.StatusInternalServerError)
@@ -434,4 +682,4 @@ func handleImageEdits(c *gin.Context, provider providers_base.ProviderInterface,
 	}
 
 	return imageVariations.ImageVariationsAction(&imageEditRequest, isModelMapped, promptTokens)
-}
\ No newline at end of file
+}
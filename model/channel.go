@@ -0,0 +1,23 @@
+package model
+
+// ChannelStatusEnabled is the status value a channel row must have to be
+// eligible for relay dispatch or failover selection.
+const ChannelStatusEnabled = 1
+
+// ListChannelIdsByType returns the ids of every enabled channel of the given
+// type, ordered by priority (highest first) and excluding any id already in
+// excluded, so a failover retry can pick a genuinely different channel
+// instead of re-resolving the one that just failed.
+func ListChannelIdsByType(channelType int, excluded []int) ([]int, error) {
+	var ids []int
+	query := DB.Table("channels").
+		Select("id").
+		Where("type = ? AND status = ?", channelType, ChannelStatusEnabled)
+	if len(excluded) > 0 {
+		query = query.Where("id NOT IN ?", excluded)
+	}
+	if err := query.Order("priority desc").Pluck("id", &ids).Error; err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
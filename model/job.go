@@ -0,0 +1,73 @@
+package model
+
+import "time"
+
+const (
+	JobStatusQueued    = "queued"
+	JobStatusRunning   = "running"
+	JobStatusSucceeded = "succeeded"
+	JobStatusFailed    = "failed"
+)
+
+// Job represents an asynchronously processed relay request (image
+// generation/edits/variations, audio transcription/translation/speech).
+// It is created by relayHelper when the caller asks for async processing
+// and consumed by the job worker pool.
+type Job struct {
+	Id               int64  `json:"id" gorm:"primaryKey"`
+	UserId           int    `json:"user_id" gorm:"index"`
+	TokenId          int    `json:"token_id" gorm:"index"`
+	ChannelId        int    `json:"channel_id"`
+	ChannelType      int    `json:"channel_type"`
+	Group            string `json:"group"`
+	RelayMode        int    `json:"relay_mode"`
+	ModelName        string `json:"model_name"`
+	ModelMapping     string `json:"model_mapping" gorm:"type:text"`
+	Payload          string `json:"-" gorm:"type:text"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	PreConsumedQuota int    `json:"pre_consumed_quota"`
+	Status           string `json:"status" gorm:"index"`
+	ResultURL        string `json:"result_url,omitempty"`
+	ResultJSON       string `json:"result_json,omitempty" gorm:"type:text"`
+	ErrorMessage     string `json:"error_message,omitempty"`
+	CreatedAt        int64  `json:"created_at" gorm:"bigint"`
+	FinishedAt       int64  `json:"finished_at,omitempty" gorm:"bigint"`
+}
+
+func CreateJob(job *Job) error {
+	job.Status = JobStatusQueued
+	job.CreatedAt = time.Now().Unix()
+	return DB.Create(job).Error
+}
+
+func GetJobById(id int64) (*Job, error) {
+	var job Job
+	err := DB.First(&job, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (job *Job) UpdateStatus(status string) error {
+	job.Status = status
+	if status == JobStatusSucceeded || status == JobStatusFailed {
+		job.FinishedAt = time.Now().Unix()
+	}
+	return DB.Model(job).Select("status", "finished_at").Updates(job).Error
+}
+
+func (job *Job) UpdateResult(resultURL string, resultJSON string) error {
+	job.Status = JobStatusSucceeded
+	job.ResultURL = resultURL
+	job.ResultJSON = resultJSON
+	job.FinishedAt = time.Now().Unix()
+	return DB.Model(job).Select("status", "result_url", "result_json", "finished_at").Updates(job).Error
+}
+
+func (job *Job) UpdateError(errMsg string) error {
+	job.Status = JobStatusFailed
+	job.ErrorMessage = errMsg
+	job.FinishedAt = time.Now().Unix()
+	return DB.Model(job).Select("status", "error_message", "finished_at").Updates(job).Error
+}
@@ -0,0 +1,14 @@
+package model
+
+// MigratedModels lists every GORM model this package defines that needs a
+// table created by AutoMigrate. This tree has no InitDB/database-setup
+// call site to wire it into; whatever owns the DB connection elsewhere
+// should call DB.AutoMigrate(model.MigratedModels()...) once at startup, or
+// CreateJob/GetContentFiltersForToken and friends will fail against a
+// fresh database with no matching tables.
+func MigratedModels() []interface{} {
+	return []interface{}{
+		&Job{},
+		&ContentFilter{},
+	}
+}
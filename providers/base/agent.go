@@ -0,0 +1,15 @@
+package providers_base
+
+import "one-api/types"
+
+// AgentChatInterface extends ChatInterface with access to the raw assistant
+// message produced by the most recently completed ChatAction call. The
+// server-side tool-call loop needs the message's `tool_calls` (and the
+// message itself, to satisfy OpenAI's requirement that an assistant
+// tool_calls message precede the corresponding role: "tool" results) which
+// ChatAction's (*types.Usage, *types.OpenAIErrorWithStatusCode) return
+// doesn't carry.
+type AgentChatInterface interface {
+	ChatInterface
+	LastResponseMessage() *types.ChatCompletionMessage
+}
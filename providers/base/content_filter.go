@@ -0,0 +1,50 @@
+package providers_base
+
+// ContentFilterSeverity mirrors the severity buckets Azure OpenAI reports
+// in its PromptFilterResults/ContentFilterResult payloads.
+type ContentFilterSeverity string
+
+const (
+	ContentFilterSeveritySafe   ContentFilterSeverity = "safe"
+	ContentFilterSeverityLow    ContentFilterSeverity = "low"
+	ContentFilterSeverityMedium ContentFilterSeverity = "medium"
+	ContentFilterSeverityHigh   ContentFilterSeverity = "high"
+)
+
+// ContentFilterCategoryResult is a single category's verdict, e.g. the
+// "hate" entry inside a ContentFilterResult.
+type ContentFilterCategoryResult struct {
+	Filtered bool                  `json:"filtered"`
+	Severity ContentFilterSeverity `json:"severity"`
+}
+
+// ContentFilterResult is the OpenAI/Azure-compatible shape returned for a
+// single piece of checked content.
+type ContentFilterResult struct {
+	Hate     ContentFilterCategoryResult `json:"hate"`
+	SelfHarm ContentFilterCategoryResult `json:"self_harm"`
+	Sexual   ContentFilterCategoryResult `json:"sexual"`
+	Violence ContentFilterCategoryResult `json:"violence"`
+}
+
+// Flagged reports whether any category was filtered.
+func (r *ContentFilterResult) Flagged() bool {
+	return r.Hate.Filtered || r.SelfHarm.Filtered || r.Sexual.Filtered || r.Violence.Filtered
+}
+
+// ContentFilterInterface is implemented by every pluggable pre/post filter
+// (local regex, blocklist, or a moderation channel). The relay runs input
+// filters before pre-quota consumption on chat messages, completion
+// prompts, single-string embedding input, speech input and image
+// generation/edit prompts, and output filters on chat completion responses
+// (streamed or not) and non-streaming completion responses. Requests served
+// through the `x-cache: true` path get input filtering only; array
+// embedding input, moderations and the tool-call agent loop are not
+// filtered at all.
+type ContentFilterInterface interface {
+	// Name identifies the filter for logging and for the `source` field
+	// attached to a filter result.
+	Name() string
+	// Check inspects a single piece of text and returns its verdict.
+	Check(text string) (*ContentFilterResult, error)
+}
@@ -0,0 +1,272 @@
+package providers_base
+
+import (
+	"encoding/json"
+	"net/http"
+	"one-api/common"
+	"one-api/types"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StreamMultiplexer sits between a provider's stream reader and the gin
+// ResponseWriter. It forwards every byte it sees straight through to the
+// client (so the SSE frame sequence is never broken) while also parsing each
+// `data: {...}` frame to count delta tokens and buffer the assistant text
+// delivered so far, so that relayHelper can account for exactly what reached
+// the client and, on a mid-stream failure, reconstruct a prefilled retry
+// request. Parsing happens on every write, so it works with any provider's
+// existing streaming code unmodified.
+type StreamMultiplexer struct {
+	gin.ResponseWriter
+	deliveredTokens  int
+	partialText      strings.Builder
+	deltas           []string
+	toolCalls        map[int]*partialToolCall
+	toolCallOrder    []int
+	finishReasonSeen bool
+	finishReason     string
+	attempt          int
+	outputFilter     func(delta string) bool
+	blocked          bool
+}
+
+// partialToolCall accumulates one tool_calls delta stream: OpenAI sends the
+// id/name once and the arguments in fragments, both keyed by the same
+// index across chunks.
+type partialToolCall struct {
+	id        string
+	name      string
+	arguments strings.Builder
+}
+
+// contentFilterBlockedFrame is written in place of the rest of the stream
+// once outputFilter blocks a delta, mirroring the non-streaming
+// content_filter_triggered error shape closely enough for a client to
+// recognize it. outputFilter reports a single bool, which covers both a
+// filter actually flagging the delta and a filter check failing closed
+// (checkFilters treats the two the same way), so this message deliberately
+// doesn't claim the content itself was flagged.
+const contentFilterBlockedFrame = `data: {"error":{"message":"the response was blocked by a content filter check","type":"content_filter_triggered"}}` + "\n\n" + "data: [DONE]\n\n"
+
+// NewStreamMultiplexer wraps the gin context's current ResponseWriter and
+// installs itself in its place so every subsequent write to c.Writer is
+// observed by the multiplexer.
+func NewStreamMultiplexer(c *gin.Context) *StreamMultiplexer {
+	mux := &StreamMultiplexer{ResponseWriter: c.Writer}
+	c.Writer = mux
+	return mux
+}
+
+// Write records any SSE chat completion chunk found in p, then forwards the
+// bytes to the underlying ResponseWriter unchanged - unless outputFilter has
+// flagged this or an earlier chunk, in which case p is dropped and replaced
+// with a single terminating content_filter_triggered frame so the flagged
+// content (and the rest of the stream) never reaches the client.
+func (m *StreamMultiplexer) Write(p []byte) (int, error) {
+	if m.blocked {
+		return len(p), nil
+	}
+	if m.recordChunk(p) {
+		m.blocked = true
+		_, err := m.ResponseWriter.Write([]byte(contentFilterBlockedFrame))
+		return len(p), err
+	}
+	return m.ResponseWriter.Write(p)
+}
+
+// SetOutputFilter installs a callback invoked with each content delta before
+// it reaches the client; returning true blocks that delta and every later
+// one. Installing no filter (the default) leaves Write's behavior
+// unchanged.
+func (m *StreamMultiplexer) SetOutputFilter(filter func(delta string) bool) {
+	m.outputFilter = filter
+}
+
+// Blocked reports whether outputFilter flagged content and the remainder of
+// the stream was suppressed.
+func (m *StreamMultiplexer) Blocked() bool {
+	return m.blocked
+}
+
+func (m *StreamMultiplexer) Flush() {
+	if flusher, ok := m.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// sseChunk is the subset of an OpenAI-style chat completion stream chunk
+// that recordChunk needs in order to track delivered content and tool
+// calls.
+type sseChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content   string `json:"content"`
+			ToolCalls []struct {
+				Index    int    `json:"index"`
+				Id       string `json:"id"`
+				Function struct {
+					Name      string `json:"name"`
+					Arguments string `json:"arguments"`
+				} `json:"function"`
+			} `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+}
+
+// recordChunk scans a raw SSE write for `data: {...}` lines and feeds any
+// content delta / finish_reason it finds into RecordDelta, so accounting
+// works regardless of how the provider chooses to flush its stream. It
+// returns true as soon as outputFilter flags a delta found in p, and then
+// records nothing from p, since Write drops all of p in that case.
+func (m *StreamMultiplexer) recordChunk(p []byte) bool {
+	var chunks []sseChunk
+	for _, line := range strings.Split(string(p), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		payload := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if payload == "" || payload == "[DONE]" {
+			continue
+		}
+		var chunk sseChunk
+		if err := json.Unmarshal([]byte(payload), &chunk); err != nil {
+			continue
+		}
+		chunks = append(chunks, chunk)
+	}
+	if m.outputFilter != nil {
+		for _, chunk := range chunks {
+			for _, choice := range chunk.Choices {
+				if choice.Delta.Content != "" && m.outputFilter(choice.Delta.Content) {
+					return true
+				}
+			}
+		}
+	}
+	for _, chunk := range chunks {
+		for _, choice := range chunk.Choices {
+			m.RecordDelta(choice.Delta.Content, choice.FinishReason)
+			for _, tc := range choice.Delta.ToolCalls {
+				m.RecordToolCallDelta(tc.Index, tc.Id, tc.Function.Name, tc.Function.Arguments)
+			}
+		}
+	}
+	return false
+}
+
+// RecordDelta records a single content delta / finish_reason pair as having
+// reached the client. Exported so a provider that doesn't emit standard SSE
+// chunks can still report delivery directly.
+func (m *StreamMultiplexer) RecordDelta(content string, finishReason string) {
+	if content != "" {
+		m.deliveredTokens++
+		m.partialText.WriteString(content)
+		m.deltas = append(m.deltas, content)
+	}
+	if finishReason != "" {
+		m.finishReasonSeen = true
+		m.finishReason = finishReason
+	}
+}
+
+// RecordToolCallDelta records one tool_call delta keyed by its index:
+// OpenAI sends a call's id/name once and its arguments in fragments, all
+// tagged with the same index across chunks, so fragments are appended
+// rather than overwritten. Exported so a provider that doesn't emit
+// standard SSE chunks can still report tool-call delivery directly.
+func (m *StreamMultiplexer) RecordToolCallDelta(index int, id string, name string, argumentsDelta string) {
+	if m.toolCalls == nil {
+		m.toolCalls = map[int]*partialToolCall{}
+	}
+	call, ok := m.toolCalls[index]
+	if !ok {
+		call = &partialToolCall{}
+		m.toolCalls[index] = call
+		m.toolCallOrder = append(m.toolCallOrder, index)
+	}
+	if id != "" {
+		call.id = id
+	}
+	if name != "" {
+		call.name = name
+	}
+	call.arguments.WriteString(argumentsDelta)
+}
+
+// PartialToolCalls returns the tool calls accumulated so far, in the order
+// their first delta arrived, for use as prefill on a failover retry. A
+// caller should only do this when FinishReasonSeen is false: once a
+// finish_reason has been seen the tool_calls response is already complete,
+// so there is nothing partial left to replay.
+func (m *StreamMultiplexer) PartialToolCalls() []types.ToolCall {
+	if len(m.toolCallOrder) == 0 {
+		return nil
+	}
+	calls := make([]types.ToolCall, 0, len(m.toolCallOrder))
+	for _, index := range m.toolCallOrder {
+		call := m.toolCalls[index]
+		toolCall := types.ToolCall{Id: call.id}
+		toolCall.Function.Name = call.name
+		toolCall.Function.Arguments = call.arguments.String()
+		calls = append(calls, toolCall)
+	}
+	return calls
+}
+
+// DeliveredTokens returns the number of content deltas actually forwarded
+// to the client so far. This counts SSE chunks, not real tokens - a
+// provider that batches several tokens into one chunk will under-report
+// here, so billing must use BilledCompletionTokens instead; this remains
+// useful as a cheap "was anything delivered at all" signal.
+func (m *StreamMultiplexer) DeliveredTokens() int {
+	return m.deliveredTokens
+}
+
+// BilledCompletionTokens runs the assistant text delivered so far through
+// the real tokenizer for model, for billing purposes - unlike
+// DeliveredTokens, which only counts SSE chunks and would wildly undercount
+// whenever a provider batches multiple tokens into one chunk.
+func (m *StreamMultiplexer) BilledCompletionTokens(model string) int {
+	return common.CountTokenInput(m.partialText.String(), model)
+}
+
+// PartialAssistantText returns the assistant text delivered so far, used to
+// build the prefill message for a failover retry.
+func (m *StreamMultiplexer) PartialAssistantText() string {
+	return m.partialText.String()
+}
+
+// FinishReasonSeen reports whether the upstream had already emitted a
+// finish_reason before the stream broke off. Tool call deltas must only be
+// replayed when this is false.
+func (m *StreamMultiplexer) FinishReasonSeen() bool {
+	return m.finishReasonSeen
+}
+
+// FinishReason returns the finish_reason the upstream emitted, or "" if the
+// stream broke off before one arrived.
+func (m *StreamMultiplexer) FinishReason() string {
+	return m.finishReason
+}
+
+// Deltas returns every non-empty content delta recorded so far, in
+// delivery order, so a cache miss can store the exact SSE frame sequence a
+// later cache hit needs to replay.
+func (m *StreamMultiplexer) Deltas() []string {
+	return m.deltas
+}
+
+// Attempt returns how many times this multiplexer has been retried.
+func (m *StreamMultiplexer) Attempt() int {
+	return m.attempt
+}
+
+// NextAttempt marks that a failover retry is starting, for policy checks
+// against RelayFailoverPolicy.MaxRetries.
+func (m *StreamMultiplexer) NextAttempt() {
+	m.attempt++
+}
@@ -0,0 +1,171 @@
+package providers_base
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ToolExecutor runs a single tool call issued by the model and returns the
+// string result that gets appended back to the conversation as a `role:
+// "tool"` message.
+type ToolExecutor interface {
+	Execute(arguments string) (string, error)
+}
+
+// toolExecutorMu guards both registries below: RegisterToolExecutor can be
+// called concurrently with in-flight GetToolExecutor lookups from the agent
+// loop, so plain map access isn't safe.
+var toolExecutorMu sync.RWMutex
+
+// globalToolExecutors holds the built-in tools available to every token.
+var globalToolExecutors = map[string]ToolExecutor{
+	"http_get": httpGetExecutor{},
+}
+
+// perTokenToolExecutors holds the per-token webhook/MCP executors registered
+// via RegisterToolExecutor, keyed first by token id.
+var perTokenToolExecutors = map[int]map[string]ToolExecutor{}
+
+// RegisterToolExecutor wires a named tool (as it appears in the request's
+// `tools` array) to the executor that should run it for a given token. Used
+// to attach per-token webhook/MCP endpoints without touching the relay
+// handler code.
+func RegisterToolExecutor(tokenId int, name string, executor ToolExecutor) {
+	toolExecutorMu.Lock()
+	defer toolExecutorMu.Unlock()
+	if perTokenToolExecutors[tokenId] == nil {
+		perTokenToolExecutors[tokenId] = map[string]ToolExecutor{}
+	}
+	perTokenToolExecutors[tokenId][name] = executor
+}
+
+// GetToolExecutor looks up the executor registered for a tool name, checking
+// the calling token's own executors before falling back to the built-ins.
+func GetToolExecutor(tokenId int, name string) (ToolExecutor, bool) {
+	toolExecutorMu.RLock()
+	defer toolExecutorMu.RUnlock()
+	if tokenExecutors, ok := perTokenToolExecutors[tokenId]; ok {
+		if executor, ok := tokenExecutors[name]; ok {
+			return executor, true
+		}
+	}
+	executor, ok := globalToolExecutors[name]
+	return executor, ok
+}
+
+// httpGetExecutor is the built-in `http_get` tool: it expects a JSON
+// argument object of the form {"url": "..."} and returns the response body.
+type httpGetExecutor struct{}
+
+func (httpGetExecutor) Execute(arguments string) (string, error) {
+	var args struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return "", fmt.Errorf("invalid http_get arguments: %w", err)
+	}
+	if args.URL == "" {
+		return "", errors.New("http_get requires a url argument")
+	}
+	if err := guardAgainstSSRF(args.URL); err != nil {
+		return "", err
+	}
+
+	client := http.Client{
+		Timeout: 10 * time.Second,
+		// Redirect targets go through the same guard as the original URL.
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("http_get stopped after 10 redirects")
+			}
+			return guardAgainstSSRF(req.URL.String())
+		},
+	}
+	resp, err := client.Get(args.URL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// guardAgainstSSRF rejects http_get targets that could be used to reach
+// internal or cloud-metadata services: only plain http(s) URLs to a host
+// that resolves solely to public, non-link-local addresses are allowed.
+func guardAgainstSSRF(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid http_get url: %w", err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("http_get only supports http/https urls, got %q", parsed.Scheme)
+	}
+	host := parsed.Hostname()
+	if host == "" {
+		return errors.New("http_get url has no host")
+	}
+
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return fmt.Errorf("http_get could not resolve host: %w", err)
+	}
+	for _, ip := range ips {
+		if isBlockedIP(ip) {
+			return fmt.Errorf("http_get may not target %s: resolves to a private or link-local address", host)
+		}
+	}
+	return nil
+}
+
+// isBlockedIP reports whether ip falls in a private, loopback, link-local,
+// or cloud metadata range that http_get must never be allowed to reach.
+func isBlockedIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	// 169.254.169.254 is covered by IsLinkLocalUnicast above, but keep an
+	// explicit check for the well-known cloud metadata address in case a
+	// future Go version narrows that classification.
+	if ip.Equal(net.IPv4(169, 254, 169, 254)) {
+		return true
+	}
+	return false
+}
+
+// WebhookExecutor dispatches a tool call to an HTTP webhook, POSTing the
+// raw tool call arguments as the request body and returning the response
+// body as the tool result.
+type WebhookExecutor struct {
+	URL string
+}
+
+func (e WebhookExecutor) Execute(arguments string) (string, error) {
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(e.URL, "application/json", strings.NewReader(arguments))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return "", fmt.Errorf("tool webhook returned status %d: %s", resp.StatusCode, string(body))
+	}
+	return string(body), nil
+}
@@ -0,0 +1,43 @@
+package types
+
+// BatchEmbeddingRequest is the body accepted by `POST /v1/embeddings/batch`:
+// like EmbeddingRequest but Input is always an array, chunked and fanned
+// out across channels by the caller.
+type BatchEmbeddingRequest struct {
+	Model          string   `json:"model"`
+	Input          []string `json:"input"`
+	EncodingFormat string   `json:"encoding_format,omitempty"`
+}
+
+type BatchEmbeddingData struct {
+	Object    string    `json:"object"`
+	Embedding []float32 `json:"embedding"`
+	Index     int       `json:"index"`
+}
+
+type BatchEmbeddingResponse struct {
+	Object string               `json:"object"`
+	Model  string               `json:"model"`
+	Data   []BatchEmbeddingData `json:"data"`
+	Usage  Usage                `json:"usage"`
+}
+
+// BatchModerationRequest is accepted both by `POST /v1/moderations` (when
+// `input` is an array rather than a single string) and internally by the
+// batch fan-out helper.
+type BatchModerationRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type ModerationResultItem struct {
+	Flagged        bool               `json:"flagged"`
+	Categories     map[string]bool    `json:"categories"`
+	CategoryScores map[string]float64 `json:"category_scores"`
+}
+
+type BatchModerationResponse struct {
+	Id      string                 `json:"id"`
+	Model   string                 `json:"model"`
+	Results []ModerationResultItem `json:"results"`
+}